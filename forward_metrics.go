@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// ForwardDirection indicates which way bytes are flowing through a forwarded
+// connection, from the perspective of the SSH server.
+type ForwardDirection int
+
+const (
+	// ForwardDirectionToChannel is used when data is being copied from the
+	// dialed (or listening) side of a forward into the SSH channel, i.e.
+	// towards the client.
+	ForwardDirectionToChannel ForwardDirection = iota
+	// ForwardDirectionFromChannel is used when data is being copied from the
+	// SSH channel into the dialed (or listening) side of a forward, i.e. away
+	// from the client.
+	ForwardDirectionFromChannel
+)
+
+// ForwardMetrics is a pluggable hook for observing direct and reverse
+// forwarded connections (TCP or Unix domain socket). Implementations must be
+// safe for concurrent use since the methods are invoked from the goroutines
+// copying each half of every forward.
+type ForwardMetrics interface {
+	// OnOpen is called once a forwarded connection has been established,
+	// before any bytes are copied.
+	OnOpen(ctx Context, network, addr string)
+	// OnBytes is called after every chunk of data copied in either
+	// direction.
+	OnBytes(ctx Context, network, addr string, dir ForwardDirection, n int)
+	// OnClose is called once a forwarded connection has ended.
+	OnClose(ctx Context, network, addr string)
+}
+
+// noopForwardMetrics is used whenever Server.ForwardMetrics is nil, so the
+// forwarding handlers don't need to nil-check on every byte copied.
+type noopForwardMetrics struct{}
+
+func (noopForwardMetrics) OnOpen(ctx Context, network, addr string)  {}
+func (noopForwardMetrics) OnClose(ctx Context, network, addr string) {}
+func (noopForwardMetrics) OnBytes(ctx Context, network, addr string, dir ForwardDirection, n int) {
+}
+
+// ForwardRateLimiter returns the token bucket that should throttle a single
+// forwarded connection, keyed however the operator likes (typically the SSH
+// user, the client's remote address, and the dial destination are all
+// reachable off ctx and the network/addr arguments). A nil return value
+// means the forward is unlimited.
+type ForwardRateLimiter func(ctx Context, network, addr string) *rate.Limiter
+
+func forwardMetricsFor(srv *Server) ForwardMetrics {
+	if srv.ForwardMetrics == nil {
+		return noopForwardMetrics{}
+	}
+	return srv.ForwardMetrics
+}
+
+func forwardLimiterFor(srv *Server, ctx Context, network, addr string) *rate.Limiter {
+	if srv.ForwardRateLimiter == nil {
+		return nil
+	}
+	return srv.ForwardRateLimiter(ctx, network, addr)
+}
+
+// meteredCopy is io.Copy with OnBytes accounting and optional token-bucket
+// throttling spliced in. When src is exhausted it closes the write side of
+// dst (if dst supports half-close) so the goroutine copying the other
+// direction observes a clean EOF instead of blocking on a full-duplex
+// connection that is otherwise still open.
+func meteredCopy(ctx Context, m ForwardMetrics, limiter *rate.Limiter, network, addr string, dir ForwardDirection, dst io.Writer, src io.Reader) (int64, error) {
+	var written int64
+	// WaitN rejects any n greater than the limiter's burst size outright
+	// rather than waiting for it, so the read buffer must never exceed the
+	// burst or every copy through a tightly bursted limiter would fail.
+	bufSize := 32 * 1024
+	if limiter != nil {
+		if b := limiter.Burst(); b > 0 && b < bufSize {
+			bufSize = b
+		}
+	}
+	buf := make([]byte, bufSize)
+	for {
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			if limiter != nil {
+				if err := limiter.WaitN(ctx, nr); err != nil {
+					return written, err
+				}
+			}
+			nw, ew := dst.Write(buf[:nr])
+			if nw > 0 {
+				written += int64(nw)
+				m.OnBytes(ctx, network, addr, dir, nw)
+			}
+			if ew != nil {
+				return written, ew
+			}
+			if nr != nw {
+				return written, io.ErrShortWrite
+			}
+		}
+		if er != nil {
+			if er != io.EOF {
+				return written, er
+			}
+			break
+		}
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	return written, nil
+}
@@ -0,0 +1,146 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type testForwardMetrics struct {
+	bytes map[ForwardDirection]int
+}
+
+func (m *testForwardMetrics) OnOpen(ctx Context, network, addr string)  {}
+func (m *testForwardMetrics) OnClose(ctx Context, network, addr string) {}
+func (m *testForwardMetrics) OnBytes(ctx Context, network, addr string, dir ForwardDirection, n int) {
+	if m.bytes == nil {
+		m.bytes = make(map[ForwardDirection]int)
+	}
+	m.bytes[dir] += n
+}
+
+func TestMeteredCopyReportsBytes(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	m := &testForwardMetrics{}
+	src := bytes.NewReader(bytes.Repeat([]byte{'a'}, 100*1024))
+	var dst bytes.Buffer
+
+	n, err := meteredCopy(ctx, m, nil, "tcp", "dest:1234", ForwardDirectionToChannel, &dst, src)
+	if err != nil {
+		t.Fatalf("meteredCopy: %v", err)
+	}
+	if n != int64(dst.Len()) || dst.Len() != 100*1024 {
+		t.Fatalf("copied %d bytes, dst has %d bytes", n, dst.Len())
+	}
+	if got := m.bytes[ForwardDirectionToChannel]; got != 100*1024 {
+		t.Fatalf("OnBytes reported %d bytes, want %d", got, 100*1024)
+	}
+}
+
+// A rate limiter configured with a burst smaller than meteredCopy's read
+// buffer is a normal way to express a modest per-forward cap. It must not
+// make every copy fail: WaitN errors immediately if asked to wait for more
+// than the burst, so the read size has to be clamped to it.
+func TestMeteredCopyRespectsSmallRateLimiterBurst(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	// A finite (but fast) limit: with rate.Inf WaitN never rejects n above
+	// the burst, so the clamp would go untested.
+	limiter := rate.NewLimiter(rate.Limit(1<<30), 8*1024)
+	src := bytes.NewReader(bytes.Repeat([]byte{'a'}, 64*1024))
+	var dst bytes.Buffer
+
+	n, err := meteredCopy(ctx, &testForwardMetrics{}, limiter, "unix", "/tmp/test.sock", ForwardDirectionFromChannel, &dst, src)
+	if err != nil {
+		t.Fatalf("meteredCopy with small-burst limiter: %v", err)
+	}
+	if n != int64(dst.Len()) || dst.Len() != 64*1024 {
+		t.Fatalf("copied %d bytes, want %d", dst.Len(), 64*1024)
+	}
+}
+
+// fakeWriteCloser records whether CloseWrite was invoked, without needing a
+// real half-closable connection.
+type fakeWriteCloser struct {
+	bytes.Buffer
+	closeWriteCalled bool
+}
+
+func (f *fakeWriteCloser) CloseWrite() error {
+	f.closeWriteCalled = true
+	return nil
+}
+
+func TestMeteredCopyCallsCloseWriteOnEOF(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	dst := &fakeWriteCloser{}
+	src := bytes.NewReader([]byte("hello"))
+
+	if _, err := meteredCopy(ctx, &testForwardMetrics{}, nil, "tcp", "dest:1234", ForwardDirectionToChannel, dst, src); err != nil {
+		t.Fatalf("meteredCopy: %v", err)
+	}
+	if !dst.closeWriteCalled {
+		t.Fatal("meteredCopy did not CloseWrite dst on a clean EOF")
+	}
+}
+
+// TestMeteredCopyHalfCloseUnblocksPeer checks that meteredCopy's CloseWrite
+// on a clean EOF is actually observed by the other end as an EOF, the way
+// the forwarding handlers rely on it to unblock the sibling copy goroutine
+// instead of hanging.
+func TestMeteredCopyHalfCloseUnblocksPeer(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	sock := filepath.Join(t.TempDir(), "halfclose.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	client, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	src := bytes.NewReader([]byte("hello"))
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := meteredCopy(ctx, &testForwardMetrics{}, nil, "unix", sock, ForwardDirectionToChannel, client, src)
+		copyDone <- err
+	}()
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatalf("reading from half-closed peer: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("read %q, want %q", got, "hello")
+	}
+	if err := <-copyDone; err != nil {
+		t.Fatalf("meteredCopy: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	forwardedStreamLocalChannelType = "forwarded-streamlocal@openssh.com"
+)
+
+// direct-streamlocal@openssh.com data struct as specified in OpenSSH's
+// PROTOCOL file, section 2.4.
+type streamLocalChannelData struct {
+	SocketPath string
+
+	Reserved0 string
+	Reserved1 uint32
+}
+
+// DirectStreamLocalHandler can be enabled by adding it to the server's
+// ChannelHandlers under direct-streamlocal@openssh.com.
+func DirectStreamLocalHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx Context) {
+	d := streamLocalChannelData{}
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+		newChan.Reject(gossh.ConnectionFailed, "error parsing forward data: "+err.Error())
+		return
+	}
+
+	if srv.LocalUnixForwardingCallback == nil || !srv.LocalUnixForwardingCallback(ctx, d.SocketPath, 0, nil) {
+		newChan.Reject(gossh.Prohibited, "streamlocal forwarding is disabled")
+		return
+	}
+
+	dial := srv.LocalUnixForwardingDialer
+	if dial == nil {
+		var dialer net.Dialer
+		dial = func(ctx Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	dconn, err := dial(ctx, "unix", d.SocketPath)
+	if err != nil {
+		newChan.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	if !srv.LocalUnixForwardingCallback(ctx, d.SocketPath, 1, dconn) {
+		dconn.Close()
+		newChan.Reject(gossh.Prohibited, "streamlocal forwarding is disabled")
+		return
+	}
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		dconn.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+
+	metrics := forwardMetricsFor(srv)
+	limiter := forwardLimiterFor(srv, ctx, "unix", d.SocketPath)
+	metrics.OnOpen(ctx, "unix", d.SocketPath)
+
+	// Each direction CloseWrites its destination on a clean EOF so the other
+	// direction's copy can observe it; ch and dconn are only fully closed
+	// once both directions have finished, not by whichever finishes first.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		meteredCopy(ctx, metrics, limiter, "unix", d.SocketPath, ForwardDirectionToChannel, ch, dconn)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		meteredCopy(ctx, metrics, limiter, "unix", d.SocketPath, ForwardDirectionFromChannel, dconn, ch)
+	}()
+	go func() {
+		wg.Wait()
+		ch.Close()
+		dconn.Close()
+		metrics.OnClose(ctx, "unix", d.SocketPath)
+		srv.LocalUnixForwardingCallback(ctx, d.SocketPath, -1, nil)
+	}()
+}
+
+type streamLocalForwardRequest struct {
+	SocketPath string
+}
+
+type streamLocalForwardCancelRequest struct {
+	SocketPath string
+}
+
+type streamLocalChannelForwardData struct {
+	SocketPath string
+	Reserved   string
+}
+
+// ForwardedUnixHandler can be enabled by creating a ForwardedUnixHandler and
+// adding the HandleSSHRequest callback to the server's RequestHandlers under
+// streamlocal-forward@openssh.com and cancel-streamlocal-forward@openssh.com.
+type ForwardedUnixHandler struct {
+	forwards map[string]net.Listener
+	sync.Mutex
+}
+
+func (h *ForwardedUnixHandler) HandleSSHRequest(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
+	h.Lock()
+	if h.forwards == nil {
+		h.forwards = make(map[string]net.Listener)
+	}
+	h.Unlock()
+	conn := ctx.Value(ContextKeyConn).(*gossh.ServerConn)
+	switch req.Type {
+	case "streamlocal-forward@openssh.com":
+		var reqPayload streamLocalForwardRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			srv.logMsg("failed to unmarshal %s payload from %s - %s", req.Type, conn.RemoteAddr().String(), err.Error())
+			return false, []byte{}
+		}
+		if srv.ReverseUnixForwardingCallback == nil {
+			return false, []byte("streamlocal forwarding is disabled")
+		}
+
+		if !srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath, 0, nil) {
+			return false, []byte("streamlocal forwarding is rejected")
+		}
+
+		os.Remove(reqPayload.SocketPath)
+		ln, err := net.Listen("unix", reqPayload.SocketPath)
+		if err != nil {
+			srv.logMsg("failed to listen on %s - %s", reqPayload.SocketPath, err.Error())
+			return false, []byte{}
+		}
+
+		srv.logMsg("streamlocal forward started on %s for %s", reqPayload.SocketPath, conn.RemoteAddr().String())
+		srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath, 1, ln)
+		h.Lock()
+		h.forwards[reqPayload.SocketPath] = ln
+		h.Unlock()
+		go func() {
+			<-ctx.Done()
+			h.Lock()
+			ln, ok := h.forwards[reqPayload.SocketPath]
+			h.Unlock()
+			if ok {
+				ln.Close()
+			}
+		}()
+		go func() {
+			var lwg sync.WaitGroup
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					if !errors.Is(err, net.ErrClosed) {
+						srv.logMsg("failed to accept connection on %s - %s", reqPayload.SocketPath, err.Error())
+					}
+					break
+				}
+				payload := gossh.Marshal(&streamLocalChannelForwardData{
+					SocketPath: reqPayload.SocketPath,
+				})
+				lwg.Add(1)
+				go func() {
+					defer lwg.Done()
+					ch, reqs, err := conn.OpenChannel(forwardedStreamLocalChannelType, payload)
+					if err != nil {
+						srv.logMsg("failed to open channel on %s for %s - %s", reqPayload.SocketPath, conn.RemoteAddr().String(), err.Error())
+						c.Close()
+						return
+					}
+
+					srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath, 2, ln)
+					srv.logMsg("opened channel on %s for %s", reqPayload.SocketPath, conn.RemoteAddr().String())
+
+					go gossh.DiscardRequests(reqs)
+
+					metrics := forwardMetricsFor(srv)
+					limiter := forwardLimiterFor(srv, ctx, "unix", reqPayload.SocketPath)
+					metrics.OnOpen(ctx, "unix", reqPayload.SocketPath)
+
+					// ch and c are only fully closed once both copy
+					// directions have finished, so a clean EOF on one side
+					// (propagated via CloseWrite inside meteredCopy) doesn't
+					// truncate data still in flight on the other.
+					var wg sync.WaitGroup
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						meteredCopy(ctx, metrics, limiter, "unix", reqPayload.SocketPath, ForwardDirectionToChannel, ch, c)
+					}()
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						meteredCopy(ctx, metrics, limiter, "unix", reqPayload.SocketPath, ForwardDirectionFromChannel, c, ch)
+					}()
+					wg.Wait()
+					ch.Close()
+					c.Close()
+					metrics.OnClose(ctx, "unix", reqPayload.SocketPath)
+
+					srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath, -2, ln)
+					srv.logMsg("closed channel on %s for %s", reqPayload.SocketPath, conn.RemoteAddr().String())
+				}()
+			}
+			lwg.Wait()
+			h.Lock()
+			delete(h.forwards, reqPayload.SocketPath)
+			h.Unlock()
+			srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath, -1, nil)
+			os.Remove(reqPayload.SocketPath)
+			srv.logMsg("streamlocal forward ended on %s for %s", reqPayload.SocketPath, conn.RemoteAddr().String())
+		}()
+		return true, nil
+
+	case "cancel-streamlocal-forward@openssh.com":
+		var reqPayload streamLocalForwardCancelRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			srv.logMsg("failed to unmarshal %s payload from %s - %s", req.Type, conn.RemoteAddr().String(), err.Error())
+			return false, []byte{}
+		}
+
+		h.Lock()
+		ln, ok := h.forwards[reqPayload.SocketPath]
+		h.Unlock()
+		srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath, -1, nil)
+		if ok {
+			srv.logMsg("streamlocal forward cancelled on %s for %s", reqPayload.SocketPath, conn.RemoteAddr().String())
+			ln.Close()
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
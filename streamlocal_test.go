@@ -0,0 +1,138 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestStreamLocalChannelDataRoundTrip(t *testing.T) {
+	want := streamLocalChannelData{SocketPath: "/tmp/upstream.sock"}
+	var got streamLocalChannelData
+	if err := gossh.Unmarshal(gossh.Marshal(&want), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.SocketPath != want.SocketPath {
+		t.Fatalf("SocketPath = %q, want %q", got.SocketPath, want.SocketPath)
+	}
+}
+
+func TestStreamLocalForwardRequestRoundTrip(t *testing.T) {
+	want := streamLocalForwardRequest{SocketPath: "/tmp/listener.sock"}
+	var got streamLocalForwardRequest
+	if err := gossh.Unmarshal(gossh.Marshal(&want), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.SocketPath != want.SocketPath {
+		t.Fatalf("SocketPath = %q, want %q", got.SocketPath, want.SocketPath)
+	}
+}
+
+type fakeNewChannel struct {
+	extraData []byte
+	acceptErr error
+
+	rejectedReason  gossh.RejectionReason
+	rejectedMessage string
+	rejected        bool
+}
+
+func (f *fakeNewChannel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	return nil, nil, f.acceptErr
+}
+
+func (f *fakeNewChannel) Reject(reason gossh.RejectionReason, message string) error {
+	f.rejected = true
+	f.rejectedReason = reason
+	f.rejectedMessage = message
+	return nil
+}
+
+func (f *fakeNewChannel) ChannelType() string { return "direct-streamlocal@openssh.com" }
+func (f *fakeNewChannel) ExtraData() []byte   { return f.extraData }
+
+func TestDirectStreamLocalHandlerRejectsWhenForwardingDisabled(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	newChan := &fakeNewChannel{
+		extraData: gossh.Marshal(&streamLocalChannelData{SocketPath: "/tmp/does-not-matter.sock"}),
+		acceptErr: errors.New("Accept should not be called"),
+	}
+
+	DirectStreamLocalHandler(&Server{}, nil, newChan, ctx)
+
+	if !newChan.rejected || newChan.rejectedReason != gossh.Prohibited {
+		t.Fatalf("got rejected=%v reason=%v, want rejected with Prohibited", newChan.rejected, newChan.rejectedReason)
+	}
+}
+
+func TestDirectStreamLocalHandlerRejectsWhenDialFails(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	sock := filepath.Join(t.TempDir(), "nobody-listening.sock")
+	newChan := &fakeNewChannel{
+		extraData: gossh.Marshal(&streamLocalChannelData{SocketPath: sock}),
+		acceptErr: errors.New("Accept should not be called"),
+	}
+	srv := &Server{
+		LocalUnixForwardingCallback: func(ctx Context, socketPath string, state int, conn net.Conn) bool {
+			return true
+		},
+	}
+
+	DirectStreamLocalHandler(srv, nil, newChan, ctx)
+
+	if !newChan.rejected || newChan.rejectedReason != gossh.ConnectionFailed {
+		t.Fatalf("got rejected=%v reason=%v, want rejected with ConnectionFailed", newChan.rejected, newChan.rejectedReason)
+	}
+}
+
+func TestDirectStreamLocalHandlerRejectsWhenPostDialCallbackVetoes(t *testing.T) {
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+
+	sock := filepath.Join(t.TempDir(), "upstream.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	newChan := &fakeNewChannel{
+		extraData: gossh.Marshal(&streamLocalChannelData{SocketPath: sock}),
+		acceptErr: errors.New("Accept should not be called"),
+	}
+	srv := &Server{
+		LocalUnixForwardingCallback: func(ctx Context, socketPath string, state int, conn net.Conn) bool {
+			// Authorize the forward up front, but veto it once the
+			// destination connection is known.
+			return state != 1
+		},
+	}
+
+	DirectStreamLocalHandler(srv, nil, newChan, ctx)
+
+	if !newChan.rejected || newChan.rejectedReason != gossh.Prohibited {
+		t.Fatalf("got rejected=%v reason=%v, want rejected with Prohibited", newChan.rejected, newChan.rejectedReason)
+	}
+
+	c := <-accepted
+	defer c.Close()
+	// The dialed connection must have been closed rather than left
+	// dangling once the post-dial callback rejected the forward.
+	if n, err := c.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Fatalf("expected dialed connection to be closed, got n=%d err=%v", n, err)
+	}
+}
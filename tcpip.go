@@ -2,7 +2,6 @@ package ssh
 
 import (
 	"errors"
-	"io"
 	"net"
 	"strconv"
 	"sync"
@@ -32,19 +31,30 @@ func DirectTCPIPHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.NewCh
 		return
 	}
 
-	if srv.LocalPortForwardingCallback == nil || !srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort) {
+	if srv.LocalPortForwardingCallback == nil || !srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort, 0, nil) {
 		newChan.Reject(gossh.Prohibited, "port forwarding is disabled")
 		return
 	}
 
 	dest := net.JoinHostPort(d.DestAddr, strconv.FormatInt(int64(d.DestPort), 10))
 
-	var dialer net.Dialer
-	dconn, err := dialer.DialContext(ctx, "tcp", dest)
+	dial := srv.LocalPortForwardingDialer
+	if dial == nil {
+		var dialer net.Dialer
+		dial = func(ctx Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	dconn, err := dial(ctx, "tcp", dest)
 	if err != nil {
 		newChan.Reject(gossh.ConnectionFailed, err.Error())
 		return
 	}
+	if !srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort, 1, dconn) {
+		dconn.Close()
+		newChan.Reject(gossh.Prohibited, "port forwarding is disabled")
+		return
+	}
 
 	ch, reqs, err := newChan.Accept()
 	if err != nil {
@@ -53,15 +63,30 @@ func DirectTCPIPHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.NewCh
 	}
 	go gossh.DiscardRequests(reqs)
 
+	metrics := forwardMetricsFor(srv)
+	limiter := forwardLimiterFor(srv, ctx, "tcp", dest)
+	metrics.OnOpen(ctx, "tcp", dest)
+
+	// Each direction CloseWrites its destination on a clean EOF so the other
+	// direction's copy can observe it; ch and dconn are only fully closed
+	// once both directions have finished, not by whichever finishes first.
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go func() {
-		defer ch.Close()
-		defer dconn.Close()
-		io.Copy(ch, dconn)
+		defer wg.Done()
+		meteredCopy(ctx, metrics, limiter, "tcp", dest, ForwardDirectionToChannel, ch, dconn)
 	}()
+	wg.Add(1)
 	go func() {
-		defer ch.Close()
-		defer dconn.Close()
-		io.Copy(dconn, ch)
+		defer wg.Done()
+		meteredCopy(ctx, metrics, limiter, "tcp", dest, ForwardDirectionFromChannel, dconn, ch)
+	}()
+	go func() {
+		wg.Wait()
+		ch.Close()
+		dconn.Close()
+		metrics.OnClose(ctx, "tcp", dest)
+		srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort, -1, nil)
 	}()
 }
 
@@ -191,22 +216,30 @@ func (h *ForwardedTCPHandler) HandleSSHRequest(ctx Context, srv *Server, req *go
 
 					go gossh.DiscardRequests(reqs)
 
+					fwdAddr := net.JoinHostPort(originAddr, strconv.Itoa(originPort))
+					metrics := forwardMetricsFor(srv)
+					limiter := forwardLimiterFor(srv, ctx, "tcp", fwdAddr)
+					metrics.OnOpen(ctx, "tcp", fwdAddr)
+
+					// ch and c are only fully closed once both copy
+					// directions have finished, so a clean EOF on one side
+					// (propagated via CloseWrite inside meteredCopy) doesn't
+					// truncate data still in flight on the other.
 					var wg sync.WaitGroup
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
-						defer ch.Close()
-						defer c.Close()
-						io.Copy(ch, c)
+						meteredCopy(ctx, metrics, limiter, "tcp", fwdAddr, ForwardDirectionToChannel, ch, c)
 					}()
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
-						defer ch.Close()
-						defer c.Close()
-						io.Copy(c, ch)
+						meteredCopy(ctx, metrics, limiter, "tcp", fwdAddr, ForwardDirectionFromChannel, c, ch)
 					}()
 					wg.Wait()
+					ch.Close()
+					c.Close()
+					metrics.OnClose(ctx, "tcp", fwdAddr)
 
 					srv.ReversePortForwardingCallback(ctx, claddr, craddr, -2, ln, nil)
 					srv.logMsg("closed channel on %s:%d for %s:%d", reqPayload.BindAddr, destPort, originAddr, originPort)